@@ -84,16 +84,34 @@ func parseArgs(sc *SscgConfig) error {
 	flag.UintVar(&sc.lifetime, "lifetime", 3650, "Certificate lifetime (days).\n\t")
 
 	// --key-strength
+	// Deprecated: use --key-type instead. Retained so existing scripts
+	// that only know about RSA keys keep working.
 	sc.keyStrength = KeyStrength2048
-	flag.Var(&sc.keyStrength, "key-strength", "Strength of the certificate private keys in bits. {512,1024,2048,4096}\n\t")
+	flag.Var(&sc.keyStrength, "key-strength", "Deprecated, use --key-type. Strength of the certificate private keys in bits. {512,1024,2048,4096}\n\t")
+
+	// --key-type
+	sc.keyType = KeyTypeRSA2048
+	flag.Var(&sc.keyType, "key-type", "Type of the certificate private keys. {rsa:2048,rsa:4096,ecdsa:p256,ecdsa:p384,ed25519}\n\t")
 
 	// --hash-alg
 	sc.hashAlgorithm = HashAlgorithmSHA256
-	flag.Var(&sc.hashAlgorithm, "hash-alg", "Hashing algorithm to use for signing. {sha256,sha384,sha512}\n\t")
+	flag.Var(&sc.hashAlgorithm, "hash-alg", "Hashing algorithm to use for signing. {sha256,sha384,sha512}\n\tIgnored for ed25519 keys; defaults to a curve-matched hash for ecdsa keys.\n\t")
 
 	// --package
 	flag.StringVar(&sc.packagename, "package", "Unknown", "The name of the package needing a certificate\n\t")
 
+	// --config
+	flag.StringVar(&sc.configFile, "config", "", "Path to a YAML or JSON file describing a CA and a list of certificate\n\tprofiles to issue under it. When set, all other certificate-shape\n\tflags are ignored in favor of the file's contents.\n\t")
+
+	// --pkcs12-file
+	flag.StringVar(&sc.pkcs12File, "pkcs12-file", "", "Path where a PKCS#12 bundle containing the service certificate,\n\tits private key, and the CA chain will be written.\n\t")
+
+	// --pkcs12-password
+	flag.StringVar(&sc.pkcs12Password, "pkcs12-password", "", "Password used to encrypt the PKCS#12 bundle.\n\t")
+
+	// --jks-file
+	flag.StringVar(&sc.jksFile, "jks-file", "", "Path where a JKS truststore containing the CA certificate will be written.\n\t")
+
 	// --ca-file
 	sc.caFile = fmt.Sprintf("%s/ca.crt", sc.cwd)
 	flag.StringVar(&sc.caFile, "ca-file", sc.caFile, "Path where the public CA certificate will be stored.\n\t")
@@ -116,19 +134,115 @@ func parseArgs(sc *SscgConfig) error {
 	// --subject-alt-name
 	flag.Var(&sc.subjectAltNames, "subject-alt-name", "An additional valid hostname for the certificate. May be specified multiple times.\n\t")
 
+	// --spiffe-id
+	flag.Var(&sc.spiffeIDs, "spiffe-id", "A spiffe://trust-domain/workload URI SAN identifying the workload this\n\tcertificate belongs to. May be specified multiple times.\n\t")
+
+	// --key-backend
+	sc.keyBackend = KeyBackendFile
+	flag.Var(&sc.keyBackend, "key-backend", "Backend used to generate and store private keys. {file,pkcs11}\n\t")
+
+	// --pkcs11-module
+	flag.StringVar(&sc.pkcs11.Module, "pkcs11-module", "", "Path to the PKCS#11 module to use when --key-backend=pkcs11.\n\t")
+
+	// --pkcs11-slot
+	flag.UintVar(&sc.pkcs11.Slot, "pkcs11-slot", 0, "PKCS#11 slot to use when --key-backend=pkcs11.\n\t")
+
+	// --pkcs11-pin
+	flag.StringVar(&sc.pkcs11.Pin, "pkcs11-pin", "", "PIN for the PKCS#11 slot when --key-backend=pkcs11.\n\t")
+
+	// --pkcs11-ca-label
+	flag.StringVar(&sc.pkcs11.CALabel, "pkcs11-ca-label", "sscg-ca", "CKA_LABEL of the CA key pair on the PKCS#11 token.\n\t")
+
+	// --pkcs11-cert-label
+	flag.StringVar(&sc.pkcs11.CertLabel, "pkcs11-cert-label", "sscg-cert", "CKA_LABEL of the service certificate key pair on the PKCS#11 token.\n\t")
+
 	// --country
 	flag.StringVar(&sc.country, "country", "US", "Certificate DN: Country (C)\n\t")
 
 	// --organization
 	flag.StringVar(&sc.organization, "organization", "Unspecified", "Certificate DN: Organization (O)\n\t")
 
+	// --ocsp-uri
+	flag.StringVar(&sc.ocspURI, "ocsp-uri", "", "URL of an OCSP responder to embed in the Authority Information Access\n\textension of issued certificates.\n\t")
+
+	// --crl-uri
+	flag.StringVar(&sc.crlURI, "crl-uri", "", "URL of a CRL to embed in the CRL Distribution Points extension of\n\tissued certificates.\n\t")
+
 	flag.Parse()
 
+	// --key-strength only still does something when --key-type wasn't
+	// given explicitly: in that case it selects the matching RSA
+	// KeyType, instead of being silently overridden by KeyType's
+	// rsa:2048 zero value.
+	keyTypeExplicit, keyStrengthExplicit := false, false
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "key-type":
+			keyTypeExplicit = true
+		case "key-strength":
+			keyStrengthExplicit = true
+		}
+	})
+	if keyStrengthExplicit && !keyTypeExplicit {
+		sc.keyType = sc.keyStrength.KeyType()
+	}
+
+	// ed25519 has no parameterized hash; it always signs with SHA-512
+	// internally, so --hash-alg doesn't apply to it.
+	if sc.keyType.IsEd25519() {
+		hashAlgExplicit := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "hash-alg" {
+				hashAlgExplicit = true
+			}
+		})
+		if hashAlgExplicit {
+			return fmt.Errorf("--hash-alg cannot be used with --key-type=ed25519")
+		}
+	} else if !sc.keyType.IsRSA() {
+		// ECDSA: default to the curve-matched hash unless the user
+		// explicitly asked for something else.
+		explicit := false
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "hash-alg" {
+				explicit = true
+			}
+		})
+		if !explicit {
+			sc.hashAlgorithm = sc.keyType.DefaultHashAlgorithm()
+		}
+	}
+
 	return nil
 }
 
 func main() {
+	// "sscg revoke" and "sscg crl" manage an existing CA's revocation
+	// database instead of issuing new certificates, so they're
+	// dispatched before parseArgs ever sees the subcommand's own flags.
+	// Neither has a --quiet/--verbose/--debug of its own, so StandardLogger
+	// (the only logger they use) always prints to stdout.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "revoke":
+			StandardLogger = log.New(os.Stdout, "[OUTPUT] ", 0)
+			if err := runRevokeCommand(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "sscg revoke: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		case "crl":
+			StandardLogger = log.New(os.Stdout, "[OUTPUT] ", 0)
+			if err := runCRLCommand(os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "sscg crl: %s\n", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+
 	var sc = new(SscgConfig)
+	defer sc.closePKCS11Session()
 
 	err := parseArgs(sc)
 	if err != nil {
@@ -155,6 +269,14 @@ func main() {
 	}
 	DebugLogger = log.New(debugIO, "[DEBUG] ", 0)
 
+	if sc.configFile != "" {
+		if err := runMultiCertConfig(sc); err != nil {
+			fmt.Fprintf(os.Stderr, "Issuing certificates from %s failed: %s\n", sc.configFile, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	DebugLogger.Printf("%+#v\n", sc)
 
 	// Compare the cert-file and cert-key-file arguments
@@ -219,24 +341,52 @@ func main() {
 	}
 	StandardLogger.Printf("Service public certificate written to %s.\n", sc.certFile)
 
-	// Write the service private key
-	if data, err = sc.svcCertificateKey.MarshalPKCS1PrivateKeyPEM(); err != nil {
-		fmt.Fprintf(os.Stderr, "Error getting service certificate data: %v\n", err)
-		os.Exit(1)
+	// Write the service private key, unless it was generated on a
+	// PKCS#11 token and never left the HSM.
+	if sc.keyBackend == KeyBackendPKCS11 {
+		VerboseLogger.Printf("Service certificate private key held on PKCS#11 token; skipping %s\n", sc.certKeyFile)
+	} else {
+		if sc.keyType.IsRSA() {
+			data, err = sc.svcCertificateKey.MarshalPKCS1PrivateKeyPEM()
+		} else {
+			// ECDSA and Ed25519 keys have no PKCS#1 representation;
+			// PKCS#8 is the portable container for them.
+			data, err = sc.svcCertificateKey.MarshalPKCS8PrivateKeyPEM()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting service certificate data: %v\n", err)
+			os.Exit(1)
+		}
+
+		if matched {
+			// If they are the same file, append to it
+			err = sc.AppendToFile(sc.certKeyFile, data)
+			if err != nil {
+				os.Exit(1)
+			}
+		} else {
+			// Otherwise, create it as normal
+			err = sc.WriteSecureFile(sc.certKeyFile, data)
+			if err != nil {
+				os.Exit(1)
+			}
+		}
+		StandardLogger.Printf("Service certificate private key written to %s.\n", sc.certKeyFile)
 	}
 
-	if matched {
-		// If they are the same file, append to it
-		err = sc.AppendToFile(sc.certKeyFile, data)
-		if err != nil {
+	if sc.pkcs12File != "" {
+		if err := sc.WritePKCS12Bundle(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing PKCS#12 bundle: %v\n", err)
 			os.Exit(1)
 		}
-	} else {
-		// Otherwise, create it as normal
-		err = sc.WriteSecureFile(sc.certKeyFile, data)
-		if err != nil {
+		StandardLogger.Printf("PKCS#12 bundle written to %s.\n", sc.pkcs12File)
+	}
+
+	if sc.jksFile != "" {
+		if err := sc.WriteJKSTruststore(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing JKS truststore: %v\n", err)
 			os.Exit(1)
 		}
+		StandardLogger.Printf("JKS truststore written to %s.\n", sc.jksFile)
 	}
-	StandardLogger.Printf("Service certificate private key written to %s.\n", sc.certKeyFile)
-}
\ No newline at end of file
+}
@@ -0,0 +1,86 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+
+	keystore "github.com/pavlo-v-chernykh/keystore-go/v4"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// WritePKCS12Bundle bundles the service certificate, its private key, and
+// the CA chain into a single PKCS#12 file at sc.pkcs12File, encrypted
+// with sc.pkcs12Password. This is for consumers (Java middleware,
+// MongoDB, ...) that can't load raw PEM directly.
+func (sc *SscgConfig) WritePKCS12Bundle() error {
+	if sc.keyBackend == KeyBackendPKCS11 {
+		return fmt.Errorf("pkcs12: cannot bundle a private key that lives on a PKCS#11 token")
+	}
+
+	caCert := sc.caCertificate.X509Certificate()
+	svcCert := sc.svcCertificate.X509Certificate()
+	svcKey := sc.svcCertificateKey.PrivateKey()
+
+	data, err := pkcs12.Modern.Encode(svcKey, svcCert, []*x509.Certificate{caCert}, sc.pkcs12Password)
+	if err != nil {
+		return fmt.Errorf("pkcs12: encoding bundle failed: %w", err)
+	}
+
+	return sc.WriteSecureFile(sc.pkcs12File, data)
+}
+
+// WriteJKSTruststore writes a JKS truststore at sc.jksFile containing only
+// the CA certificate, for Java consumers that need to trust certificates
+// issued by sc rather than present one.
+func (sc *SscgConfig) WriteJKSTruststore() error {
+	caCert := sc.caCertificate.X509Certificate()
+
+	ks := keystore.New()
+	entry := keystore.TrustedCertificateEntry{
+		CreationTime: caCert.NotBefore,
+		Certificate: keystore.Certificate{
+			Type:    "X509",
+			Content: caCert.Raw,
+		},
+	}
+	if err := ks.SetTrustedCertificateEntry("ca", entry); err != nil {
+		return fmt.Errorf("jks: adding CA entry failed: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := ks.Store(&buf, []byte(sc.pkcs12Password)); err != nil {
+		return fmt.Errorf("jks: writing truststore failed: %w", err)
+	}
+
+	return sc.WriteSecureFile(sc.jksFile, buf.Bytes())
+}
@@ -0,0 +1,593 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// SscgConfig carries every setting parsed from the command line (or a
+// --config profile) plus the CA and service certificate/key material
+// generated from them. A single SscgConfig is built per run of sscg; the
+// multi-certificate --config path reuses one instance across profiles.
+type SscgConfig struct {
+	cwd string
+
+	quiet        bool
+	verbose      bool
+	debug        bool
+	printVersion bool
+
+	lifetime uint
+
+	keyStrength   KeyStrength
+	keyType       KeyType
+	hashAlgorithm HashAlgorithm
+
+	packagename string
+
+	caFile      string
+	certFile    string
+	certKeyFile string
+
+	hostname        string
+	subjectAltNames SubjectAltNameList
+	spiffeIDs       SpiffeIDList
+
+	country      string
+	organization string
+
+	keyBackend KeyBackend
+	pkcs11     PKCS11Config
+	pkcs11Conn *pkcs11Connection
+
+	configFile string
+
+	pkcs12File     string
+	pkcs12Password string
+	jksFile        string
+
+	ocspURI string
+	crlURI  string
+
+	// extKeyUsageOverride replaces createServiceCert's default EKU
+	// selection when non-nil. Only --config profiles set this, via
+	// CertProfile.EKUs; the plain CLI flags have no equivalent.
+	extKeyUsageOverride []x509.ExtKeyUsage
+
+	caCertificate     *Certificate
+	caCertificateKey  *CertificateKey
+	svcCertificate    *Certificate
+	svcCertificateKey *CertificateKey
+}
+
+// KeyStrength is the legacy RSA-only key size selector. KeyType
+// supersedes it, but it's kept around for --key-strength: parseArgs
+// converts it to the matching RSA KeyType when --key-type wasn't given
+// explicitly.
+type KeyStrength int
+
+const (
+	// KeyStrength512 is a 512-bit RSA key. Not recommended.
+	KeyStrength512 KeyStrength = 512
+	// KeyStrength1024 is a 1024-bit RSA key. Not recommended.
+	KeyStrength1024 KeyStrength = 1024
+	// KeyStrength2048 is a 2048-bit RSA key. The default.
+	KeyStrength2048 KeyStrength = 2048
+	// KeyStrength4096 is a 4096-bit RSA key.
+	KeyStrength4096 KeyStrength = 4096
+)
+
+// String implements flag.Value.
+func (ks *KeyStrength) String() string {
+	if ks == nil {
+		return "2048"
+	}
+	return fmt.Sprintf("%d", int(*ks))
+}
+
+// Set implements flag.Value.
+func (ks *KeyStrength) Set(value string) error {
+	switch value {
+	case "512":
+		*ks = KeyStrength512
+	case "1024":
+		*ks = KeyStrength1024
+	case "2048":
+		*ks = KeyStrength2048
+	case "4096":
+		*ks = KeyStrength4096
+	default:
+		return fmt.Errorf("unknown key strength: %s", value)
+	}
+	return nil
+}
+
+// KeyType returns the RSA KeyType matching this KeyStrength, for
+// parseArgs to fall back on when --key-strength was given explicitly but
+// --key-type wasn't.
+func (ks KeyStrength) KeyType() KeyType {
+	switch ks {
+	case KeyStrength512:
+		return KeyTypeRSA512
+	case KeyStrength1024:
+		return KeyTypeRSA1024
+	case KeyStrength4096:
+		return KeyTypeRSA4096
+	default:
+		return KeyTypeRSA2048
+	}
+}
+
+// HashAlgorithm selects the digest algorithm used when signing
+// certificates.
+type HashAlgorithm int
+
+const (
+	// HashAlgorithmSHA256 signs with SHA-256. The default.
+	HashAlgorithmSHA256 HashAlgorithm = iota
+	// HashAlgorithmSHA384 signs with SHA-384.
+	HashAlgorithmSHA384
+	// HashAlgorithmSHA512 signs with SHA-512.
+	HashAlgorithmSHA512
+)
+
+// String implements flag.Value.
+func (h *HashAlgorithm) String() string {
+	if h == nil {
+		return "sha256"
+	}
+	switch *h {
+	case HashAlgorithmSHA384:
+		return "sha384"
+	case HashAlgorithmSHA512:
+		return "sha512"
+	default:
+		return "sha256"
+	}
+}
+
+// Set implements flag.Value.
+func (h *HashAlgorithm) Set(value string) error {
+	switch value {
+	case "sha256":
+		*h = HashAlgorithmSHA256
+	case "sha384":
+		*h = HashAlgorithmSHA384
+	case "sha512":
+		*h = HashAlgorithmSHA512
+	default:
+		return fmt.Errorf("unknown hash algorithm: %s", value)
+	}
+	return nil
+}
+
+// SubjectAltNameList collects the values of a repeatable
+// --subject-alt-name flag.
+type SubjectAltNameList []string
+
+// String implements flag.Value.
+func (s *SubjectAltNameList) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%v", []string(*s))
+}
+
+// Set implements flag.Value, appending another SAN each time
+// --subject-alt-name is given.
+func (s *SubjectAltNameList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// Certificate wraps an issued x509.Certificate along with the PEM
+// marshalling sscg writes to disk.
+type Certificate struct {
+	cert *x509.Certificate
+}
+
+// MarshalPEM renders the certificate as a PEM-encoded "CERTIFICATE"
+// block.
+func (c *Certificate) MarshalPEM() ([]byte, error) {
+	if c == nil || c.cert == nil {
+		return nil, fmt.Errorf("certificate has not been generated")
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), nil
+}
+
+// X509Certificate returns the underlying *x509.Certificate, for callers
+// (PKCS#12/JKS bundling, CRL signing) that need the stdlib type directly.
+func (c *Certificate) X509Certificate() *x509.Certificate {
+	return c.cert
+}
+
+// CertificateKey wraps the crypto.Signer backing a certificate's private
+// key, whether it lives in memory (file backend) or on a PKCS#11 token.
+type CertificateKey struct {
+	signer crypto.Signer
+}
+
+// PrivateKey returns the underlying crypto.Signer.
+func (k *CertificateKey) PrivateKey() crypto.Signer {
+	if k == nil {
+		return nil
+	}
+	return k.signer
+}
+
+// MarshalPKCS1PrivateKeyPEM renders the key as a PEM-encoded PKCS#1
+// "RSA PRIVATE KEY" block. Only valid for RSA keys.
+func (k *CertificateKey) MarshalPKCS1PrivateKeyPEM() ([]byte, error) {
+	rsaKey, ok := k.signer.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key of type %T has no PKCS#1 representation", k.signer)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(rsaKey),
+	}), nil
+}
+
+// MarshalPKCS8PrivateKeyPEM renders the key as a PEM-encoded PKCS#8
+// "PRIVATE KEY" block. Valid for RSA, ECDSA, and Ed25519 keys.
+func (k *CertificateKey) MarshalPKCS8PrivateKeyPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.signer)
+	if err != nil {
+		return nil, fmt.Errorf("marshalling PKCS#8 private key: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// SamePath reports whether a and b name the same file on disk, resolving
+// symlinks and relative paths first. Neither path needs to exist yet.
+func (sc *SscgConfig) SamePath(a, b string) (bool, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return false, fmt.Errorf("resolving %s: %w", a, err)
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return false, fmt.Errorf("resolving %s: %w", b, err)
+	}
+	return absA == absB, nil
+}
+
+// WriteSecureFile creates path (or truncates it if it already exists)
+// with permissions limited to the owner, then writes data to it.
+func (sc *SscgConfig) WriteSecureFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return nil
+}
+
+// AppendToFile appends data to an existing owner-only-readable file,
+// used when the certificate and key are configured to share one file.
+func (sc *SscgConfig) AppendToFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("appending to %s: %w", path, err)
+	}
+	return nil
+}
+
+// CertificateDebug prints the CA and service certificates (and, since
+// --debug warns it will, their private keys) to DebugLogger.
+func (sc *SscgConfig) CertificateDebug() {
+	if sc.caCertificate != nil {
+		DebugLogger.Printf("CA certificate:\n%+v\n", sc.caCertificate.cert)
+	}
+	if sc.caCertificateKey != nil {
+		DebugLogger.Printf("CA private key:\n%+v\n", sc.caCertificateKey.signer)
+	}
+	if sc.svcCertificate != nil {
+		DebugLogger.Printf("Service certificate:\n%+v\n", sc.svcCertificate.cert)
+	}
+	if sc.svcCertificateKey != nil {
+		DebugLogger.Printf("Service private key:\n%+v\n", sc.svcCertificateKey.signer)
+	}
+}
+
+// LoadCA loads an existing CA certificate and private key from disk so
+// that --config's "reuse existing" mode can issue more certificates
+// under it.
+func (sc *SscgConfig) LoadCA(caFile, caKeyFile string) error {
+	cert, signer, err := loadCAKeyPair(caFile, caKeyFile)
+	if err != nil {
+		return err
+	}
+	sc.caCertificate = &Certificate{cert: cert}
+	sc.caCertificateKey = &CertificateKey{signer: signer}
+	return nil
+}
+
+// generateKey creates a new private key on the file backend matching
+// sc.keyType.
+func (sc *SscgConfig) generateKey() (crypto.Signer, error) {
+	switch sc.keyType {
+	case KeyTypeECDSAP256:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeECDSAP384:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyTypeEd25519:
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		return priv, err
+	default:
+		return rsa.GenerateKey(rand.Reader, sc.keyType.RSABits())
+	}
+}
+
+// keyFor generates (or looks up) the private key for either the CA or
+// the service certificate, honoring sc.keyBackend.
+func (sc *SscgConfig) keyFor(forCA bool) (crypto.Signer, error) {
+	if sc.keyBackend == KeyBackendPKCS11 {
+		label := sc.pkcs11.CertLabel
+		if forCA {
+			label = sc.pkcs11.CALabel
+		}
+
+		ctx, session, err := sc.pkcs11Session()
+		if err != nil {
+			return nil, err
+		}
+		return newPKCS11Signer(ctx, session, label, sc.keyType.RSABits())
+	}
+	return sc.generateKey()
+}
+
+// pkcs11Session returns the PKCS#11 session shared across every key this
+// SscgConfig acquires, opening it on first use. Reusing one session (as
+// opposed to opening a fresh one per key) is what lets a single run
+// generate both the CA key and the service certificate key on the same
+// token without re-initializing the module.
+func (sc *SscgConfig) pkcs11Session() (*pkcs11.Ctx, pkcs11.SessionHandle, error) {
+	if sc.pkcs11Conn == nil {
+		ctx, session, err := openPKCS11Session(&sc.pkcs11)
+		if err != nil {
+			return nil, 0, err
+		}
+		sc.pkcs11Conn = &pkcs11Connection{ctx: ctx, session: session}
+	}
+	return sc.pkcs11Conn.ctx, sc.pkcs11Conn.session, nil
+}
+
+// closePKCS11Session releases the PKCS#11 session opened by
+// pkcs11Session, if one was ever opened. Safe to call even when the file
+// key backend was used.
+func (sc *SscgConfig) closePKCS11Session() {
+	if sc.pkcs11Conn != nil {
+		closePKCS11Session(sc.pkcs11Conn.ctx, sc.pkcs11Conn.session)
+		sc.pkcs11Conn = nil
+	}
+}
+
+// keyTypeFromPublicKey classifies pub closely enough for
+// signatureAlgorithmFor to pick the right signature algorithm for
+// whichever key is actually doing the signing. For a leaf certificate
+// that's the CA's key, not the leaf's own key, so this has to be derived
+// from the signer rather than read off sc.keyType (which issueFromProfile
+// may have since changed to the leaf's key type). The exact RSA/ECDSA
+// size or curve doesn't matter here, since signatureAlgorithmFor only
+// distinguishes RSA from ECDSA from Ed25519.
+func keyTypeFromPublicKey(pub crypto.PublicKey) KeyType {
+	switch pub.(type) {
+	case ed25519.PublicKey:
+		return KeyTypeEd25519
+	case *ecdsa.PublicKey:
+		return KeyTypeECDSAP256
+	default:
+		return KeyTypeRSA2048
+	}
+}
+
+// signatureAlgorithmFor maps a key type and hash algorithm to the
+// x509.SignatureAlgorithm used to sign a certificate. Ed25519 has a
+// single, fixed signature scheme and ignores the hash algorithm.
+func signatureAlgorithmFor(kt KeyType, h HashAlgorithm) x509.SignatureAlgorithm {
+	if kt.IsEd25519() {
+		return x509.PureEd25519
+	}
+
+	ecdsaKey := kt == KeyTypeECDSAP256 || kt == KeyTypeECDSAP384
+	switch h {
+	case HashAlgorithmSHA384:
+		if ecdsaKey {
+			return x509.ECDSAWithSHA384
+		}
+		return x509.SHA384WithRSA
+	case HashAlgorithmSHA512:
+		if ecdsaKey {
+			return x509.ECDSAWithSHA512
+		}
+		return x509.SHA512WithRSA
+	default:
+		if ecdsaKey {
+			return x509.ECDSAWithSHA256
+		}
+		return x509.SHA256WithRSA
+	}
+}
+
+// newSerialNumber returns a random serial number suitable for a new
+// certificate, per RFC 5280's recommendation of an unpredictable value.
+func newSerialNumber() (*big.Int, error) {
+	limit := new(big.Int).Lsh(big.NewInt(1), 159)
+	return rand.Int(rand.Reader, limit)
+}
+
+// createPrivateCA generates (or, on the PKCS#11 backend, locates) a
+// private key, then issues a self-signed CA certificate from it.
+func (sc *SscgConfig) createPrivateCA() error {
+	key, err := sc.keyFor(true)
+	if err != nil {
+		return fmt.Errorf("generating CA key: %w", err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return fmt.Errorf("generating CA serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   fmt.Sprintf("%s CA", sc.packagename),
+			Country:      []string{sc.country},
+			Organization: []string{sc.organization},
+		},
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, int(sc.lifetime)),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		SignatureAlgorithm:    signatureAlgorithmFor(keyTypeFromPublicKey(key.Public()), sc.hashAlgorithm),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return fmt.Errorf("signing CA certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("parsing generated CA certificate: %w", err)
+	}
+
+	sc.caCertificate = &Certificate{cert: cert}
+	sc.caCertificateKey = &CertificateKey{signer: key}
+
+	return nil
+}
+
+// createServiceCert generates (or locates, on the PKCS#11 backend) a
+// private key, then issues a certificate for it signed by the CA
+// generated (or loaded) by createPrivateCA/LoadCA.
+func (sc *SscgConfig) createServiceCert() error {
+	if sc.caCertificate == nil || sc.caCertificateKey == nil {
+		return fmt.Errorf("cannot issue a service certificate without a CA")
+	}
+
+	key, err := sc.keyFor(false)
+	if err != nil {
+		return fmt.Errorf("generating service key: %w", err)
+	}
+
+	serial, err := newSerialNumber()
+	if err != nil {
+		return fmt.Errorf("generating service certificate serial number: %w", err)
+	}
+
+	uris, err := sc.spiffeIDs.URIs()
+	if err != nil {
+		return fmt.Errorf("parsing --spiffe-id: %w", err)
+	}
+
+	extKeyUsage := sc.extKeyUsageOverride
+	if extKeyUsage == nil {
+		extKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		if len(uris) > 0 {
+			// A SPIFFE SVID is used for mutual TLS between workloads, so
+			// it needs to be able to authenticate as a client as well.
+			extKeyUsage = append(extKeyUsage, x509.ExtKeyUsageClientAuth)
+		}
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			CommonName:   sc.hostname,
+			Country:      []string{sc.country},
+			Organization: []string{sc.organization},
+		},
+		DNSNames:              append([]string{sc.hostname}, sc.subjectAltNames...),
+		URIs:                  uris,
+		NotBefore:             now,
+		NotAfter:              now.AddDate(0, 0, int(sc.lifetime)),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:           extKeyUsage,
+		BasicConstraintsValid: true,
+		IsCA:                  false,
+		// Signed by the CA's key, so the signature algorithm must match
+		// the CA's key type, not the service key's (sc.keyType, which
+		// --config profiles can override per certificate).
+		SignatureAlgorithm: signatureAlgorithmFor(keyTypeFromPublicKey(sc.caCertificateKey.signer.Public()), sc.hashAlgorithm),
+	}
+
+	if sc.ocspURI != "" {
+		template.OCSPServer = []string{sc.ocspURI}
+	}
+	if sc.crlURI != "" {
+		template.CRLDistributionPoints = []string{sc.crlURI}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, sc.caCertificate.cert, key.Public(), sc.caCertificateKey.signer)
+	if err != nil {
+		return fmt.Errorf("signing service certificate: %w", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("parsing generated service certificate: %w", err)
+	}
+
+	sc.svcCertificate = &Certificate{cert: cert}
+	sc.svcCertificateKey = &CertificateKey{signer: key}
+
+	return nil
+}
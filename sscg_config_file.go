@@ -0,0 +1,247 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ekuNames maps the CertProfile.EKUs strings to the x509.ExtKeyUsage
+// constants they select.
+var ekuNames = map[string]x509.ExtKeyUsage{
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// parseEKUs resolves a CertProfile's EKUs strings into the
+// x509.ExtKeyUsage values createServiceCert should embed.
+func parseEKUs(names []string) ([]x509.ExtKeyUsage, error) {
+	ekus := make([]x509.ExtKeyUsage, 0, len(names))
+	for _, name := range names {
+		eku, ok := ekuNames[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown eku: %s", name)
+		}
+		ekus = append(ekus, eku)
+	}
+	return ekus, nil
+}
+
+// CAProfile describes how the CA for a config-driven issuance run should
+// be obtained: generated fresh, or loaded from an existing CA so that
+// sscg can be re-run to add more certificates to the same PKI.
+type CAProfile struct {
+	// Reuse loads CAFile/CAKeyFile from disk instead of generating a new
+	// CA. CAKeyFile is ignored (and may be omitted) when KeyBackend is
+	// pkcs11, since the CA key is looked up on the token instead.
+	Reuse bool `yaml:"reuse" json:"reuse"`
+
+	CAFile    string `yaml:"ca_file" json:"ca_file"`
+	CAKeyFile string `yaml:"ca_key_file" json:"ca_key_file"`
+}
+
+// CertProfile describes a single certificate to issue under the
+// configured CA.
+type CertProfile struct {
+	Hostname        string   `yaml:"hostname" json:"hostname"`
+	SubjectAltNames []string `yaml:"subject_alt_names" json:"subject_alt_names"`
+	EKUs            []string `yaml:"ekus" json:"ekus"`
+	KeyType         string   `yaml:"key_type" json:"key_type"`
+	Lifetime        uint     `yaml:"lifetime" json:"lifetime"`
+	CertFile        string   `yaml:"cert_file" json:"cert_file"`
+	CertKeyFile     string   `yaml:"cert_key_file" json:"cert_key_file"`
+}
+
+// MultiCertConfig is the top-level shape of the --config file: one CA
+// section plus the list of certificates to issue under it.
+type MultiCertConfig struct {
+	CA    CAProfile     `yaml:"ca" json:"ca"`
+	Certs []CertProfile `yaml:"certs" json:"certs"`
+}
+
+// loadMultiCertConfig reads and parses a --config file. The format is
+// chosen from the file extension: .yaml/.yml is parsed as YAML, anything
+// else is parsed as JSON.
+func loadMultiCertConfig(path string) (*MultiCertConfig, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	cfg := &MultiCertConfig{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s as YAML: %w", path, err)
+		}
+	default:
+		if err := json.Unmarshal(raw, cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s as JSON: %w", path, err)
+		}
+	}
+
+	if len(cfg.Certs) == 0 {
+		return nil, fmt.Errorf("%s defines no certificates under \"certs\"", path)
+	}
+
+	return cfg, nil
+}
+
+// runMultiCertConfig drives --config mode: it obtains a CA (generating or
+// reusing it per CAProfile.Reuse) and then issues every CertProfile under
+// it, reusing sc's usual CA-plus-service-cert machinery and output
+// helpers for each one.
+func runMultiCertConfig(sc *SscgConfig) error {
+	defer sc.closePKCS11Session()
+
+	cfg, err := loadMultiCertConfig(sc.configFile)
+	if err != nil {
+		return err
+	}
+
+	if cfg.CA.Reuse {
+		VerboseLogger.Printf("Reusing existing CA from %s\n", cfg.CA.CAFile)
+		if err := sc.LoadCA(cfg.CA.CAFile, cfg.CA.CAKeyFile); err != nil {
+			return fmt.Errorf("loading existing CA: %w", err)
+		}
+	} else {
+		sc.caFile = cfg.CA.CAFile
+		VerboseLogger.Printf("Generating private CA\n")
+		if err := sc.createPrivateCA(); err != nil {
+			return fmt.Errorf("creating private CA: %w", err)
+		}
+
+		data, err := sc.caCertificate.MarshalPEM()
+		if err != nil {
+			return fmt.Errorf("marshalling CA certificate: %w", err)
+		}
+		if err := sc.WriteSecureFile(sc.caFile, data); err != nil {
+			return err
+		}
+		StandardLogger.Printf("CA public certificate written to %s.\n", sc.caFile)
+	}
+
+	// Snapshot the CLI-level defaults once, so a profile that omits
+	// lifetime/key_type gets *these* rather than whatever the previous
+	// profile in the list happened to set on the shared sc.
+	defaultLifetime := sc.lifetime
+	defaultKeyType := sc.keyType
+
+	for i, profile := range cfg.Certs {
+		if err := issueFromProfile(sc, profile, defaultLifetime, defaultKeyType); err != nil {
+			return fmt.Errorf("certs[%d] (%s): %w", i, profile.Hostname, err)
+		}
+	}
+
+	return nil
+}
+
+// issueFromProfile configures sc for a single CertProfile, issues the
+// certificate under the already-loaded/generated CA, and writes out its
+// PEM files. defaultLifetime/defaultKeyType are the CLI-level settings to
+// fall back on when the profile doesn't override them, since sc is
+// reused (and mutated) across every profile in cfg.Certs.
+func issueFromProfile(sc *SscgConfig, profile CertProfile, defaultLifetime uint, defaultKeyType KeyType) error {
+	sc.hostname = profile.Hostname
+	sc.subjectAltNames = nil
+	for _, san := range profile.SubjectAltNames {
+		if err := sc.subjectAltNames.Set(san); err != nil {
+			return fmt.Errorf("subject alt name %q: %w", san, err)
+		}
+	}
+	sc.certFile = profile.CertFile
+	sc.certKeyFile = profile.CertKeyFile
+
+	sc.lifetime = defaultLifetime
+	if profile.Lifetime != 0 {
+		sc.lifetime = profile.Lifetime
+	}
+
+	sc.keyType = defaultKeyType
+	if profile.KeyType != "" {
+		if err := sc.keyType.Set(profile.KeyType); err != nil {
+			return err
+		}
+	}
+
+	sc.extKeyUsageOverride = nil
+	if len(profile.EKUs) > 0 {
+		ekus, err := parseEKUs(profile.EKUs)
+		if err != nil {
+			return err
+		}
+		sc.extKeyUsageOverride = ekus
+	}
+
+	if err := sc.createServiceCert(); err != nil {
+		return fmt.Errorf("creating service certificate: %w", err)
+	}
+
+	certData, err := sc.svcCertificate.MarshalPEM()
+	if err != nil {
+		return fmt.Errorf("marshalling service certificate: %w", err)
+	}
+	if err := sc.WriteSecureFile(sc.certFile, certData); err != nil {
+		return err
+	}
+	StandardLogger.Printf("Service public certificate written to %s.\n", sc.certFile)
+
+	if sc.keyBackend == KeyBackendPKCS11 {
+		VerboseLogger.Printf("Service certificate private key held on PKCS#11 token; skipping %s\n", sc.certKeyFile)
+		return nil
+	}
+
+	var keyData []byte
+	if sc.keyType.IsRSA() {
+		keyData, err = sc.svcCertificateKey.MarshalPKCS1PrivateKeyPEM()
+	} else {
+		keyData, err = sc.svcCertificateKey.MarshalPKCS8PrivateKeyPEM()
+	}
+	if err != nil {
+		return fmt.Errorf("marshalling service certificate key: %w", err)
+	}
+	if err := sc.WriteSecureFile(sc.certKeyFile, keyData); err != nil {
+		return err
+	}
+	StandardLogger.Printf("Service certificate private key written to %s.\n", sc.certKeyFile)
+
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"testing"
+)
+
+func init() {
+	// issueFromProfile logs through the package-level loggers, which
+	// main() normally initializes; tests that call it directly need them
+	// set up too.
+	StandardLogger = log.New(ioutil.Discard, "", 0)
+	VerboseLogger = log.New(ioutil.Discard, "", 0)
+	DebugLogger = log.New(ioutil.Discard, "", 0)
+}
+
+func TestParseEKUs(t *testing.T) {
+	ekus, err := parseEKUs([]string{"serverAuth", "clientAuth", "ocspSigning"})
+	if err != nil {
+		t.Fatalf("parseEKUs returned unexpected error: %v", err)
+	}
+
+	want := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageOCSPSigning}
+	if len(ekus) != len(want) {
+		t.Fatalf("parseEKUs returned %d EKUs, want %d", len(ekus), len(want))
+	}
+	for i := range want {
+		if ekus[i] != want[i] {
+			t.Errorf("ekus[%d] = %v, want %v", i, ekus[i], want[i])
+		}
+	}
+}
+
+func TestParseEKUsUnknown(t *testing.T) {
+	if _, err := parseEKUs([]string{"bogusUsage"}); err == nil {
+		t.Fatal("parseEKUs accepted an unknown EKU name")
+	}
+}
+
+func TestParseEKUsEmpty(t *testing.T) {
+	ekus, err := parseEKUs(nil)
+	if err != nil {
+		t.Fatalf("parseEKUs returned unexpected error: %v", err)
+	}
+	if len(ekus) != 0 {
+		t.Errorf("parseEKUs(nil) = %v, want empty", ekus)
+	}
+}
+
+// TestIssueFromProfileResetsStaleState guards against a profile that
+// omits key_type/lifetime inheriting whatever the previous profile in
+// cfg.Certs left on the shared SscgConfig, rather than the CLI default,
+// since issueFromProfile mutates one SscgConfig across every profile.
+func TestIssueFromProfileResetsStaleState(t *testing.T) {
+	dir := t.TempDir()
+
+	sc := &SscgConfig{
+		packagename:  "test",
+		country:      "US",
+		organization: "Test",
+		lifetime:     3650,
+		keyType:      KeyTypeRSA2048,
+	}
+	if err := sc.createPrivateCA(); err != nil {
+		t.Fatalf("createPrivateCA: %v", err)
+	}
+
+	const defaultLifetime = uint(3650)
+	const defaultKeyType = KeyTypeRSA2048
+
+	withOverride := CertProfile{
+		Hostname:    "a.example.org",
+		KeyType:     "ecdsa:p384",
+		Lifetime:    30,
+		CertFile:    filepath.Join(dir, "a.pem"),
+		CertKeyFile: filepath.Join(dir, "a-key.pem"),
+	}
+	if err := issueFromProfile(sc, withOverride, defaultLifetime, defaultKeyType); err != nil {
+		t.Fatalf("issueFromProfile(withOverride): %v", err)
+	}
+	if sc.svcCertificate.X509Certificate().PublicKeyAlgorithm != x509.ECDSA {
+		t.Fatalf("setup: cert[0] public key algorithm = %v, want ECDSA", sc.svcCertificate.X509Certificate().PublicKeyAlgorithm)
+	}
+
+	noOverride := CertProfile{
+		Hostname:    "b.example.org",
+		CertFile:    filepath.Join(dir, "b.pem"),
+		CertKeyFile: filepath.Join(dir, "b-key.pem"),
+	}
+	if err := issueFromProfile(sc, noOverride, defaultLifetime, defaultKeyType); err != nil {
+		t.Fatalf("issueFromProfile(noOverride): %v", err)
+	}
+
+	cert := sc.svcCertificate.X509Certificate()
+	if cert.PublicKeyAlgorithm != x509.RSA {
+		t.Errorf("cert[1] public key algorithm = %v, want RSA (stale ecdsa:p384 from cert[0] leaked through)", cert.PublicKeyAlgorithm)
+	}
+	gotLifetimeDays := int(cert.NotAfter.Sub(cert.NotBefore).Hours() / 24)
+	if gotLifetimeDays != 3650 {
+		t.Errorf("cert[1] lifetime = %d days, want 3650 (stale 30 from cert[0] leaked through)", gotLifetimeDays)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto/x509"
+	"testing"
+)
+
+func TestSignatureAlgorithmFor(t *testing.T) {
+	cases := []struct {
+		kt   KeyType
+		hash HashAlgorithm
+		want x509.SignatureAlgorithm
+	}{
+		{KeyTypeRSA2048, HashAlgorithmSHA256, x509.SHA256WithRSA},
+		{KeyTypeRSA2048, HashAlgorithmSHA384, x509.SHA384WithRSA},
+		{KeyTypeRSA2048, HashAlgorithmSHA512, x509.SHA512WithRSA},
+		{KeyTypeECDSAP256, HashAlgorithmSHA256, x509.ECDSAWithSHA256},
+		{KeyTypeECDSAP384, HashAlgorithmSHA384, x509.ECDSAWithSHA384},
+		{KeyTypeECDSAP384, HashAlgorithmSHA512, x509.ECDSAWithSHA512},
+		{KeyTypeEd25519, HashAlgorithmSHA256, x509.PureEd25519},
+		{KeyTypeEd25519, HashAlgorithmSHA512, x509.PureEd25519},
+	}
+	for _, c := range cases {
+		if got := signatureAlgorithmFor(c.kt, c.hash); got != c.want {
+			t.Errorf("signatureAlgorithmFor(%v, %v) = %v, want %v", c.kt, c.hash, got, c.want)
+		}
+	}
+}
+
+func TestNewSerialNumberIsPositiveAndUnique(t *testing.T) {
+	a, err := newSerialNumber()
+	if err != nil {
+		t.Fatalf("newSerialNumber returned unexpected error: %v", err)
+	}
+	b, err := newSerialNumber()
+	if err != nil {
+		t.Fatalf("newSerialNumber returned unexpected error: %v", err)
+	}
+
+	if a.Sign() <= 0 {
+		t.Errorf("newSerialNumber() = %v, want a positive value", a)
+	}
+	if a.Cmp(b) == 0 {
+		t.Error("two consecutive newSerialNumber() calls returned the same value")
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import "fmt"
+
+// KeyType identifies both the algorithm family and the strength/curve of a
+// certificate private key. It supersedes the older, RSA-only KeyStrength
+// for all new code, but KeyStrength is retained so existing callers of
+// --key-strength keep working.
+type KeyType int
+
+const (
+	// KeyTypeRSA2048 is a 2048-bit RSA key. This remains the default.
+	KeyTypeRSA2048 KeyType = iota
+	// KeyTypeRSA4096 is a 4096-bit RSA key.
+	KeyTypeRSA4096
+	// KeyTypeECDSAP256 is an ECDSA key on the NIST P-256 curve.
+	KeyTypeECDSAP256
+	// KeyTypeECDSAP384 is an ECDSA key on the NIST P-384 curve.
+	KeyTypeECDSAP384
+	// KeyTypeEd25519 is an Ed25519 key.
+	KeyTypeEd25519
+	// KeyTypeRSA512 is a 512-bit RSA key. Not recommended; exists only so
+	// --key-strength=512 still does something when --key-type isn't given.
+	KeyTypeRSA512
+	// KeyTypeRSA1024 is a 1024-bit RSA key. Not recommended; exists only
+	// so --key-strength=1024 still does something when --key-type isn't
+	// given.
+	KeyTypeRSA1024
+)
+
+// String implements flag.Value.
+func (kt *KeyType) String() string {
+	if kt == nil {
+		return "rsa:2048"
+	}
+	switch *kt {
+	case KeyTypeRSA512:
+		return "rsa:512"
+	case KeyTypeRSA1024:
+		return "rsa:1024"
+	case KeyTypeRSA4096:
+		return "rsa:4096"
+	case KeyTypeECDSAP256:
+		return "ecdsa:p256"
+	case KeyTypeECDSAP384:
+		return "ecdsa:p384"
+	case KeyTypeEd25519:
+		return "ed25519"
+	default:
+		return "rsa:2048"
+	}
+}
+
+// Set implements flag.Value.
+func (kt *KeyType) Set(value string) error {
+	switch value {
+	case "rsa:512":
+		*kt = KeyTypeRSA512
+	case "rsa:1024":
+		*kt = KeyTypeRSA1024
+	case "rsa:2048":
+		*kt = KeyTypeRSA2048
+	case "rsa:4096":
+		*kt = KeyTypeRSA4096
+	case "ecdsa:p256":
+		*kt = KeyTypeECDSAP256
+	case "ecdsa:p384":
+		*kt = KeyTypeECDSAP384
+	case "ed25519":
+		*kt = KeyTypeEd25519
+	default:
+		return fmt.Errorf("unknown key type: %s", value)
+	}
+	return nil
+}
+
+// IsRSA reports whether this key type is an RSA key, i.e. whether
+// --hash-alg and the legacy PKCS#1 marshalling path still apply to it.
+func (kt KeyType) IsRSA() bool {
+	switch kt {
+	case KeyTypeRSA512, KeyTypeRSA1024, KeyTypeRSA2048, KeyTypeRSA4096:
+		return true
+	default:
+		return false
+	}
+}
+
+// RSABits returns the RSA modulus size this key type selects. Only valid
+// when IsRSA() is true.
+func (kt KeyType) RSABits() int {
+	switch kt {
+	case KeyTypeRSA512:
+		return 512
+	case KeyTypeRSA1024:
+		return 1024
+	case KeyTypeRSA4096:
+		return 4096
+	default:
+		return 2048
+	}
+}
+
+// IsEd25519 reports whether this key type is Ed25519, which has a fixed
+// signature algorithm and ignores --hash-alg entirely.
+func (kt KeyType) IsEd25519() bool {
+	return kt == KeyTypeEd25519
+}
+
+// DefaultHashAlgorithm returns the hash algorithm that matches this key
+// type's curve when the user hasn't overridden --hash-alg. ECDSA keys
+// conventionally pair P-256 with SHA-256 and P-384 with SHA-384; RSA keeps
+// whatever default sscg already used.
+func (kt KeyType) DefaultHashAlgorithm() HashAlgorithm {
+	switch kt {
+	case KeyTypeECDSAP384:
+		return HashAlgorithmSHA384
+	default:
+		return HashAlgorithmSHA256
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import "testing"
+
+func TestKeyTypeSetAndString(t *testing.T) {
+	cases := []struct {
+		value string
+		want  KeyType
+	}{
+		{"rsa:512", KeyTypeRSA512},
+		{"rsa:1024", KeyTypeRSA1024},
+		{"rsa:2048", KeyTypeRSA2048},
+		{"rsa:4096", KeyTypeRSA4096},
+		{"ecdsa:p256", KeyTypeECDSAP256},
+		{"ecdsa:p384", KeyTypeECDSAP384},
+		{"ed25519", KeyTypeEd25519},
+	}
+	for _, c := range cases {
+		var kt KeyType
+		if err := kt.Set(c.value); err != nil {
+			t.Errorf("Set(%q) returned unexpected error: %v", c.value, err)
+			continue
+		}
+		if kt != c.want {
+			t.Errorf("Set(%q) = %v, want %v", c.value, kt, c.want)
+		}
+		if got := kt.String(); got != c.value {
+			t.Errorf("String() after Set(%q) = %q, want %q", c.value, got, c.value)
+		}
+	}
+
+	var kt KeyType
+	if err := kt.Set("rsa:3072"); err == nil {
+		t.Error("Set(\"rsa:3072\") expected an error, got nil")
+	}
+}
+
+func TestKeyTypeIsRSA(t *testing.T) {
+	rsaTypes := []KeyType{KeyTypeRSA512, KeyTypeRSA1024, KeyTypeRSA2048, KeyTypeRSA4096}
+	for _, kt := range rsaTypes {
+		if !kt.IsRSA() {
+			t.Errorf("IsRSA() = false for %v, want true", kt)
+		}
+	}
+
+	nonRSATypes := []KeyType{KeyTypeECDSAP256, KeyTypeECDSAP384, KeyTypeEd25519}
+	for _, kt := range nonRSATypes {
+		if kt.IsRSA() {
+			t.Errorf("IsRSA() = true for %v, want false", kt)
+		}
+	}
+}
+
+func TestKeyTypeRSABits(t *testing.T) {
+	cases := map[KeyType]int{
+		KeyTypeRSA512:  512,
+		KeyTypeRSA1024: 1024,
+		KeyTypeRSA2048: 2048,
+		KeyTypeRSA4096: 4096,
+	}
+	for kt, want := range cases {
+		if got := kt.RSABits(); got != want {
+			t.Errorf("RSABits() for %v = %d, want %d", kt, got, want)
+		}
+	}
+}
+
+func TestKeyTypeDefaultHashAlgorithm(t *testing.T) {
+	if got := KeyTypeECDSAP384.DefaultHashAlgorithm(); got != HashAlgorithmSHA384 {
+		t.Errorf("DefaultHashAlgorithm() for ecdsa:p384 = %v, want sha384", got)
+	}
+	if got := KeyTypeRSA2048.DefaultHashAlgorithm(); got != HashAlgorithmSHA256 {
+		t.Errorf("DefaultHashAlgorithm() for rsa:2048 = %v, want sha256", got)
+	}
+}
+
+func TestKeyStrengthKeyType(t *testing.T) {
+	cases := map[KeyStrength]KeyType{
+		KeyStrength512:  KeyTypeRSA512,
+		KeyStrength1024: KeyTypeRSA1024,
+		KeyStrength2048: KeyTypeRSA2048,
+		KeyStrength4096: KeyTypeRSA4096,
+	}
+	for ks, want := range cases {
+		if got := ks.KeyType(); got != want {
+			t.Errorf("KeyStrength(%d).KeyType() = %v, want %v", ks, got, want)
+		}
+	}
+}
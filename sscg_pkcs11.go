@@ -0,0 +1,314 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// pkcs1v15DigestInfoPrefix holds the DER-encoded ASN.1 prefix that
+// crypto/rsa.SignPKCS1v15 prepends to a digest before RSA-encrypting it,
+// keyed by hash algorithm. CKM_RSA_PKCS performs only the raw PKCS#1 v1.5
+// pad-and-encrypt step, so callers have to supply this prefix themselves
+// for the result to verify as a standard PKCS#1v1.5 signature.
+var pkcs1v15DigestInfoPrefix = map[crypto.Hash][]byte{
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// KeyBackend selects where private key material for the CA and/or the
+// service certificate is generated and stored.
+type KeyBackend int
+
+const (
+	// KeyBackendFile stores keys as PEM files on disk (the default).
+	KeyBackendFile KeyBackend = iota
+
+	// KeyBackendPKCS11 generates and stores keys on a PKCS#11 token and
+	// never brings the private key material into process memory in a
+	// form that could be written to disk.
+	KeyBackendPKCS11
+)
+
+// String implements flag.Value.
+func (kb *KeyBackend) String() string {
+	if kb == nil {
+		return "file"
+	}
+	switch *kb {
+	case KeyBackendPKCS11:
+		return "pkcs11"
+	default:
+		return "file"
+	}
+}
+
+// Set implements flag.Value.
+func (kb *KeyBackend) Set(value string) error {
+	switch value {
+	case "file":
+		*kb = KeyBackendFile
+	case "pkcs11":
+		*kb = KeyBackendPKCS11
+	default:
+		return fmt.Errorf("unknown key backend: %s", value)
+	}
+	return nil
+}
+
+// pkcs11Connection holds one open PKCS#11 session, shared across every
+// key a single SscgConfig run acquires. Opening a fresh session per key
+// would call C_Initialize on the same module a second time before the
+// first session's C_Finalize, which PKCS#11 rejects with
+// CKR_CRYPTOKI_ALREADY_INITIALIZED.
+type pkcs11Connection struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+// PKCS11Config holds the parameters needed to talk to a PKCS#11 token.
+type PKCS11Config struct {
+	// Module is the path to the PKCS#11 shared library (e.g.
+	// /usr/lib64/softhsm/libsofthsm2.so).
+	Module string
+
+	// Slot is the PKCS#11 slot to open a session against.
+	Slot uint
+
+	// Pin is the user PIN for the slot.
+	Pin string
+
+	// CALabel is the CKA_LABEL under which the CA key pair is generated
+	// or looked up.
+	CALabel string
+
+	// CertLabel is the CKA_LABEL under which the service certificate
+	// key pair is generated or looked up.
+	CertLabel string
+}
+
+// pkcs11Signer implements crypto.Signer on top of a key pair that lives on
+// a PKCS#11 token. The private key handle never leaves the token; Sign()
+// delegates the actual signing operation to the HSM.
+type pkcs11Signer struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	pub     crypto.PublicKey
+	privKey pkcs11.ObjectHandle
+	mech    []*pkcs11.Mechanism
+}
+
+// Public implements crypto.Signer.
+func (s *pkcs11Signer) Public() crypto.PublicKey {
+	return s.pub
+}
+
+// Sign implements crypto.Signer by delegating the signing operation to the
+// PKCS#11 token holding the private key. CKM_RSA_PKCS only pads and
+// RSA-encrypts exactly the bytes it's given, so the DigestInfo prefix
+// that makes the result a standards-compliant PKCS#1v1.5 signature has
+// to be prepended here, matching what crypto/rsa.SignPKCS1v15 does
+// in-process.
+func (s *pkcs11Signer) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	prefix, ok := pkcs1v15DigestInfoPrefix[opts.HashFunc()]
+	if !ok {
+		return nil, fmt.Errorf("pkcs11: unsupported hash algorithm %v", opts.HashFunc())
+	}
+
+	if err := s.ctx.SignInit(s.session, s.mech, s.privKey); err != nil {
+		return nil, fmt.Errorf("pkcs11: sign init failed: %w", err)
+	}
+
+	sig, err := s.ctx.Sign(s.session, append(prefix, digest...))
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: sign failed: %w", err)
+	}
+
+	return sig, nil
+}
+
+// openPKCS11Session loads the configured PKCS#11 module, opens a
+// read-write session against the configured slot, and logs in with the
+// configured PIN.
+func openPKCS11Session(cfg *PKCS11Config) (*pkcs11.Ctx, pkcs11.SessionHandle, error) {
+	if cfg.Module == "" {
+		return nil, 0, errors.New("pkcs11: --pkcs11-module is required when --key-backend=pkcs11")
+	}
+
+	ctx := pkcs11.New(cfg.Module)
+	if ctx == nil {
+		return nil, 0, fmt.Errorf("pkcs11: unable to load module %s", cfg.Module)
+	}
+
+	if err := ctx.Initialize(); err != nil {
+		return nil, 0, fmt.Errorf("pkcs11: initialize failed: %w", err)
+	}
+
+	session, err := ctx.OpenSession(cfg.Slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		ctx.Finalize()
+		return nil, 0, fmt.Errorf("pkcs11: open session failed: %w", err)
+	}
+
+	if err := ctx.Login(session, pkcs11.CKU_USER, cfg.Pin); err != nil {
+		ctx.CloseSession(session)
+		ctx.Finalize()
+		return nil, 0, fmt.Errorf("pkcs11: login failed: %w", err)
+	}
+
+	return ctx, session, nil
+}
+
+// closePKCS11Session logs out and releases the PKCS#11 session opened by
+// openPKCS11Session.
+func closePKCS11Session(ctx *pkcs11.Ctx, session pkcs11.SessionHandle) {
+	ctx.Logout(session)
+	ctx.CloseSession(session)
+	ctx.Finalize()
+}
+
+// findPKCS11Object returns the handle of the first object on the token
+// matching class and label, and false if no such object exists.
+func findPKCS11Object(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, class uint, label string) (pkcs11.ObjectHandle, bool, error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+
+	if err := ctx.FindObjectsInit(session, template); err != nil {
+		return 0, false, fmt.Errorf("pkcs11: find objects init failed: %w", err)
+	}
+	defer ctx.FindObjectsFinal(session)
+
+	handles, _, err := ctx.FindObjects(session, 1)
+	if err != nil {
+		return 0, false, fmt.Errorf("pkcs11: find objects failed: %w", err)
+	}
+	if len(handles) == 0 {
+		return 0, false, nil
+	}
+	return handles[0], true, nil
+}
+
+// newPKCS11Signer looks up an existing RSA key pair on ctx/session under
+// the given label and, only if none exists yet, generates a new one.
+// Reusing the existing pair is what lets sscg be re-run against the same
+// token (e.g. --config's CA-reuse mode) without minting a fresh CA key
+// under a duplicate label every time. The caller owns ctx/session's
+// lifetime (open once, shared across every key acquired in the run) and
+// is responsible for closing it.
+func newPKCS11Signer(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, label string, bits int) (*pkcs11Signer, error) {
+	if privHandle, ok, err := findPKCS11Object(ctx, session, pkcs11.CKO_PRIVATE_KEY, label); err != nil {
+		return nil, err
+	} else if ok {
+		pubHandle, ok, err := findPKCS11Object(ctx, session, pkcs11.CKO_PUBLIC_KEY, label)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("pkcs11: found private key labeled %q but no matching public key", label)
+		}
+
+		pub, err := rsaPublicKeyFromPKCS11(ctx, session, pubHandle)
+		if err != nil {
+			return nil, err
+		}
+
+		return &pkcs11Signer{
+			ctx:     ctx,
+			session: session,
+			pub:     pub,
+			privKey: privHandle,
+			mech:    []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)},
+		}, nil
+	}
+
+	pubTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, bits),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{0x01, 0x00, 0x01}),
+		pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+	}
+	privTemplate := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+		pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+		pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+	}
+
+	pubHandle, privHandle, err := ctx.GenerateKeyPair(session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		pubTemplate, privTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: generate key pair failed: %w", err)
+	}
+
+	pub, err := rsaPublicKeyFromPKCS11(ctx, session, pubHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkcs11Signer{
+		ctx:     ctx,
+		session: session,
+		pub:     pub,
+		privKey: privHandle,
+		mech:    []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)},
+	}, nil
+}
+
+// rsaPublicKeyFromPKCS11 reads the CKA_MODULUS and CKA_PUBLIC_EXPONENT
+// attributes off a public key object and assembles an *rsa.PublicKey from
+// them.
+func rsaPublicKeyFromPKCS11(ctx *pkcs11.Ctx, session pkcs11.SessionHandle, pub pkcs11.ObjectHandle) (*rsa.PublicKey, error) {
+	attrs, err := ctx.GetAttributeValue(session, pub, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("pkcs11: reading public key attributes failed: %w", err)
+	}
+
+	n := new(big.Int).SetBytes(attrs[0].Value)
+	e := new(big.Int).SetBytes(attrs[1].Value)
+
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
@@ -0,0 +1,375 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"time"
+)
+
+// crlReasons maps the CRL reason names accepted by --reason to the RFC
+// 5280 CRLReason integer codes.
+var crlReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"caCompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+}
+
+// crlReasonCode looks up the RFC 5280 CRLReason code for a --reason
+// value.
+func crlReasonCode(reason string) (int, error) {
+	code, ok := crlReasons[reason]
+	if !ok {
+		return 0, fmt.Errorf("unknown revocation reason: %s", reason)
+	}
+	return code, nil
+}
+
+// isNotExist reports whether err indicates a missing file.
+func isNotExist(err error) bool {
+	return os.IsNotExist(err)
+}
+
+// revokedCertificateEntry builds the x509.RevocationListEntry for a
+// single revocation database record, including the reasonCode extension
+// that --reason recorded at revoke time. The deprecated
+// pkix.RevokedCertificate has no reason field, so using it here would
+// silently drop that data from the signed CRL.
+func revokedCertificateEntry(serial *big.Int, entry RevocationEntry) x509.RevocationListEntry {
+	return x509.RevocationListEntry{
+		SerialNumber:   serial,
+		RevocationTime: entry.RevokedAt,
+		ReasonCode:     entry.ReasonCode,
+	}
+}
+
+// RevocationEntry records when and why a single certificate serial was
+// revoked. Entries are kept forever; sscg never forgets a revocation.
+type RevocationEntry struct {
+	Serial       string    `json:"serial"`
+	RevokedAt    time.Time `json:"revoked_at"`
+	ReasonCode   int       `json:"reason_code"`
+	ReasonString string    `json:"reason"`
+}
+
+// RevocationDB is sscg's on-disk revocation database: a JSON object
+// mapping certificate serial (decimal string) to its RevocationEntry.
+type RevocationDB map[string]RevocationEntry
+
+// loadRevocationDB reads a revocation database from path. A missing file
+// is treated as an empty, freshly-started database rather than an error,
+// since "sscg revoke" may be the first revocation ever recorded.
+func loadRevocationDB(path string) (RevocationDB, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		if isNotExist(err) {
+			return RevocationDB{}, nil
+		}
+		return nil, fmt.Errorf("reading revocation database %s: %w", path, err)
+	}
+
+	db := RevocationDB{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &db); err != nil {
+			return nil, fmt.Errorf("parsing revocation database %s: %w", path, err)
+		}
+	}
+	return db, nil
+}
+
+// saveRevocationDB writes the revocation database back to path as
+// indented JSON, so it remains diffable and hand-editable in a pinch.
+func saveRevocationDB(path string, db RevocationDB) error {
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding revocation database: %w", err)
+	}
+	return ioutil.WriteFile(path, data, 0600)
+}
+
+// runRevokeCommand implements "sscg revoke": it loads the CA, marks one
+// or more certificates (given as serials or PEM files) as revoked in the
+// on-disk revocation database, and leaves CRL generation to "sscg crl".
+func runRevokeCommand(args []string) error {
+	fs := flag.NewFlagSet("revoke", flag.ExitOnError)
+	caFile := fs.String("ca-file", "", "Path to the CA certificate.")
+	caKeyFile := fs.String("ca-key-file", "", "Path to the CA private key. Ignored when --key-backend=pkcs11.")
+	dbFile := fs.String("db", "revocations.json", "Path to the revocation database.")
+	reason := fs.String("reason", "unspecified", "Revocation reason. {unspecified,keyCompromise,caCompromise,affiliationChanged,superseded,cessationOfOperation,certificateHold}")
+	pk := addPKCS11Flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		return fmt.Errorf("at least one serial number or certificate PEM file is required")
+	}
+
+	if _, _, err := loadRevocationCA(*caFile, *caKeyFile, pk); err != nil {
+		return fmt.Errorf("loading CA: %w", err)
+	}
+
+	reasonCode, err := crlReasonCode(*reason)
+	if err != nil {
+		return err
+	}
+
+	db, err := loadRevocationDB(*dbFile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	for _, arg := range fs.Args() {
+		serial, err := serialFromArg(arg)
+		if err != nil {
+			return fmt.Errorf("%s: %w", arg, err)
+		}
+
+		db[serial] = RevocationEntry{
+			Serial:       serial,
+			RevokedAt:    now,
+			ReasonCode:   reasonCode,
+			ReasonString: *reason,
+		}
+		StandardLogger.Printf("Revoked serial %s (%s)\n", serial, *reason)
+	}
+
+	return saveRevocationDB(*dbFile, db)
+}
+
+// runCRLCommand implements "sscg crl": it loads the CA and the
+// revocation database and signs a fresh CRL listing every revoked
+// serial.
+func runCRLCommand(args []string) error {
+	fs := flag.NewFlagSet("crl", flag.ExitOnError)
+	caFile := fs.String("ca-file", "", "Path to the CA certificate.")
+	caKeyFile := fs.String("ca-key-file", "", "Path to the CA private key. Ignored when --key-backend=pkcs11.")
+	dbFile := fs.String("db", "revocations.json", "Path to the revocation database.")
+	out := fs.String("out", "ca.crl", "Path where the signed CRL will be written.")
+	pemOutput := fs.Bool("pem", false, "Write the CRL PEM-encoded instead of raw DER.")
+	nextUpdateDays := fs.Uint("next-update", 7, "Number of days until the CRL's nextUpdate.")
+	pk := addPKCS11Flags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	caCert, caKey, err := loadRevocationCA(*caFile, *caKeyFile, pk)
+	if err != nil {
+		return fmt.Errorf("loading CA: %w", err)
+	}
+
+	db, err := loadRevocationDB(*dbFile)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	template := &x509.RevocationList{
+		Number:     big.NewInt(now.Unix()),
+		ThisUpdate: now,
+		NextUpdate: now.AddDate(0, 0, int(*nextUpdateDays)),
+	}
+	for _, entry := range db {
+		serial, ok := new(big.Int).SetString(entry.Serial, 10)
+		if !ok {
+			return fmt.Errorf("revocation database contains invalid serial %q", entry.Serial)
+		}
+		template.RevokedCertificateEntries = append(template.RevokedCertificateEntries, revokedCertificateEntry(serial, entry))
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, template, caCert, caKey)
+	if err != nil {
+		return fmt.Errorf("signing CRL: %w", err)
+	}
+
+	data := der
+	if *pemOutput {
+		data = pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	}
+
+	if err := ioutil.WriteFile(*out, data, 0644); err != nil {
+		return fmt.Errorf("writing CRL to %s: %w", *out, err)
+	}
+	StandardLogger.Printf("CRL with %d revoked certificate(s) written to %s.\n", len(template.RevokedCertificateEntries), *out)
+
+	return nil
+}
+
+// pkcs11RevocationFlags bundles the --key-backend/--pkcs11-* flags shared
+// by "sscg revoke" and "sscg crl", so a CA created with
+// --key-backend=pkcs11 (whose key never touches disk) can still be used
+// to sign revocations and CRLs.
+type pkcs11RevocationFlags struct {
+	backend KeyBackend
+	config  PKCS11Config
+}
+
+// addPKCS11Flags registers the --key-backend/--pkcs11-* flags on fs,
+// mirroring the flags parseArgs registers for certificate issuance.
+func addPKCS11Flags(fs *flag.FlagSet) *pkcs11RevocationFlags {
+	pk := &pkcs11RevocationFlags{backend: KeyBackendFile}
+	fs.Var(&pk.backend, "key-backend", "Backend used to load the CA private key. {file,pkcs11}\n\t")
+	fs.StringVar(&pk.config.Module, "pkcs11-module", "", "Path to the PKCS#11 module to use when --key-backend=pkcs11.\n\t")
+	fs.UintVar(&pk.config.Slot, "pkcs11-slot", 0, "PKCS#11 slot to use when --key-backend=pkcs11.\n\t")
+	fs.StringVar(&pk.config.Pin, "pkcs11-pin", "", "PIN for the PKCS#11 slot when --key-backend=pkcs11.\n\t")
+	fs.StringVar(&pk.config.CALabel, "pkcs11-ca-label", "sscg-ca", "CKA_LABEL of the CA key pair on the PKCS#11 token.\n\t")
+	return pk
+}
+
+// loadRevocationCA loads the CA certificate from caFile and, depending on
+// pk.backend, either reads the matching private key from caKeyFile or
+// looks up the CA key pair on the configured PKCS#11 token by label.
+func loadRevocationCA(caFile, caKeyFile string, pk *pkcs11RevocationFlags) (*x509.Certificate, crypto.Signer, error) {
+	cert, err := loadCACertificate(caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if pk.backend == KeyBackendPKCS11 {
+		// The session is intentionally left open for the rest of the
+		// process: the signer returned here still needs it for the
+		// Sign() call that happens after loadRevocationCA returns
+		// (signing the revocation/CRL), and revoke/crl are one-shot
+		// commands that exit right after, so there's no second key
+		// acquisition in the same run to share it with.
+		ctx, session, err := openPKCS11Session(&pk.config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		signer, err := newPKCS11Signer(ctx, session, pk.config.CALabel, 2048)
+		if err != nil {
+			return nil, nil, err
+		}
+		return cert, signer, nil
+	}
+
+	_, signer, err := loadCAKeyPair(caFile, caKeyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cert, signer, nil
+}
+
+// loadCACertificate reads and parses a CA certificate PEM file.
+func loadCACertificate(caFile string) (*x509.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", caFile, err)
+	}
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil {
+		return nil, fmt.Errorf("%s does not contain PEM data", caFile)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", caFile, err)
+	}
+	return cert, nil
+}
+
+// loadCAKeyPair reads the CA certificate and private key from disk. It
+// accepts RSA, ECDSA, and Ed25519 keys in either PKCS#1 (RSA only) or
+// PKCS#8 form, matching every key type createPrivateCA can produce.
+func loadCAKeyPair(caFile, caKeyFile string) (*x509.Certificate, crypto.Signer, error) {
+	cert, err := loadCACertificate(caFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(caKeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading %s: %w", caKeyFile, err)
+	}
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("%s does not contain PEM data", caKeyFile)
+	}
+
+	signer, err := parsePrivateKeySigner(keyBlock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parsing %s: %w", caKeyFile, err)
+	}
+
+	return cert, signer, nil
+}
+
+// parsePrivateKeySigner parses a PEM block holding a private key in
+// either PKCS#1 or PKCS#8 form and returns it as a crypto.Signer.
+func parsePrivateKeySigner(block *pem.Block) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("private key type %T does not implement crypto.Signer", key)
+	}
+	return signer, nil
+}
+
+// serialFromArg accepts either a bare decimal serial number or the path
+// to a certificate PEM file, and returns the decimal serial either way.
+func serialFromArg(arg string) (string, error) {
+	if _, ok := new(big.Int).SetString(arg, 10); ok {
+		return arg, nil
+	}
+
+	raw, err := ioutil.ReadFile(arg)
+	if err != nil {
+		return "", fmt.Errorf("not a decimal serial and not a readable file: %w", err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return "", fmt.Errorf("%s does not contain PEM data", arg)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("parsing %s: %w", arg, err)
+	}
+	return cert.SerialNumber.String(), nil
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestCrlReasonCode(t *testing.T) {
+	cases := map[string]int{
+		"unspecified":          0,
+		"keyCompromise":        1,
+		"caCompromise":         2,
+		"affiliationChanged":   3,
+		"superseded":           4,
+		"cessationOfOperation": 5,
+		"certificateHold":      6,
+	}
+	for reason, want := range cases {
+		got, err := crlReasonCode(reason)
+		if err != nil {
+			t.Errorf("crlReasonCode(%q) returned unexpected error: %v", reason, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("crlReasonCode(%q) = %d, want %d", reason, got, want)
+		}
+	}
+
+	if _, err := crlReasonCode("bogus"); err == nil {
+		t.Error("crlReasonCode(\"bogus\") expected an error, got nil")
+	}
+}
+
+func TestRevokedCertificateEntryCarriesReasonCode(t *testing.T) {
+	serial := big.NewInt(12345)
+	now := time.Now().UTC()
+	entry := RevocationEntry{
+		Serial:       serial.String(),
+		RevokedAt:    now,
+		ReasonCode:   1,
+		ReasonString: "keyCompromise",
+	}
+
+	rcEntry := revokedCertificateEntry(serial, entry)
+	if rcEntry.SerialNumber.Cmp(serial) != 0 {
+		t.Errorf("SerialNumber = %v, want %v", rcEntry.SerialNumber, serial)
+	}
+	if !rcEntry.RevocationTime.Equal(now) {
+		t.Errorf("RevocationTime = %v, want %v", rcEntry.RevocationTime, now)
+	}
+	if rcEntry.ReasonCode != 1 {
+		t.Errorf("ReasonCode = %d, want 1 (the --reason captured at revoke time must reach the signed CRL)", rcEntry.ReasonCode)
+	}
+}
+
+func TestSerialFromArgDecimal(t *testing.T) {
+	got, err := serialFromArg("12345")
+	if err != nil {
+		t.Fatalf("serialFromArg returned unexpected error: %v", err)
+	}
+	if got != "12345" {
+		t.Errorf("serialFromArg(\"12345\") = %q, want %q", got, "12345")
+	}
+}
+
+func TestSerialFromArgMissingFile(t *testing.T) {
+	if _, err := serialFromArg("/no/such/file.pem"); err == nil {
+		t.Error("serialFromArg expected an error for a missing, non-decimal argument")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SpiffeIDList collects the values of a repeatable --spiffe-id flag, each
+// of which becomes a URI SAN on the issued service certificate.
+type SpiffeIDList []string
+
+// String implements flag.Value.
+func (s *SpiffeIDList) String() string {
+	if s == nil {
+		return ""
+	}
+	return strings.Join(*s, ",")
+}
+
+// Set implements flag.Value, appending another SPIFFE ID each time
+// --spiffe-id is given.
+func (s *SpiffeIDList) Set(value string) error {
+	if _, err := parseSpiffeID(value); err != nil {
+		return err
+	}
+	*s = append(*s, value)
+	return nil
+}
+
+// URIs parses every collected SPIFFE ID into a *url.URL, ready to be
+// added to a certificate's URI SAN list.
+func (s SpiffeIDList) URIs() ([]*url.URL, error) {
+	uris := make([]*url.URL, 0, len(s))
+	for _, id := range s {
+		u, err := parseSpiffeID(id)
+		if err != nil {
+			return nil, err
+		}
+		uris = append(uris, u)
+	}
+	return uris, nil
+}
+
+// parseSpiffeID validates that value is a well-formed
+// spiffe://trust-domain/workload URI, per the SPIFFE ID spec: scheme
+// "spiffe", a non-empty host (the trust domain), and no query or
+// fragment.
+func parseSpiffeID(value string) (*url.URL, error) {
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: %w", value, err)
+	}
+	if u.Scheme != "spiffe" {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: scheme must be \"spiffe\"", value)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: missing trust domain", value)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid SPIFFE ID %q: query and fragment are not allowed", value)
+	}
+	return u, nil
+}
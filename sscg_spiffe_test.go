@@ -0,0 +1,88 @@
+// Copyright (c) 2016, Stephen Gallagher <sgallagh@redhat.com>
+// All rights reserved.
+//
+// Redistribution and use in source and binary forms, with or without
+// modification, are permitted provided that the following conditions are met:
+//
+// 1. Redistributions of source code must retain the above copyright notice,
+//    this list of conditions and the following disclaimer.
+//
+// 2. Redistributions in binary form must reproduce the above copyright notice,
+//    this list of conditions and the following disclaimer in the documentation
+//    and/or other materials provided with the distribution.
+//
+// 3. Neither the name of the copyright holder nor the names of its
+//    contributors may be used to endorse or promote products derived from this
+//    software without specific prior written permission.
+//
+// THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+// AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+// IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE
+// ARE DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE
+// LIABLE FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR
+// CONSEQUENTIAL DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF
+// SUBSTITUTE GOODS OR SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS
+// INTERRUPTION) HOWEVER CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN
+// CONTRACT, STRICT LIABILITY, OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE)
+// ARISING IN ANY WAY OUT OF THE USE OF THIS SOFTWARE, EVEN IF ADVISED OF THE
+// POSSIBILITY OF SUCH DAMAGE.
+
+package main
+
+import "testing"
+
+func TestParseSpiffeID(t *testing.T) {
+	valid := []string{
+		"spiffe://example.org/workload",
+		"spiffe://example.org/ns/default/sa/web",
+	}
+	for _, id := range valid {
+		if _, err := parseSpiffeID(id); err != nil {
+			t.Errorf("parseSpiffeID(%q) returned unexpected error: %v", id, err)
+		}
+	}
+
+	invalid := []string{
+		"https://example.org/workload",
+		"spiffe:///workload",
+		"spiffe://example.org/workload?query=1",
+		"spiffe://example.org/workload#fragment",
+		"not a uri at all",
+	}
+	for _, id := range invalid {
+		if _, err := parseSpiffeID(id); err == nil {
+			t.Errorf("parseSpiffeID(%q) expected an error, got nil", id)
+		}
+	}
+}
+
+func TestSpiffeIDListURIs(t *testing.T) {
+	var list SpiffeIDList
+	if err := list.Set("spiffe://example.org/web"); err != nil {
+		t.Fatalf("Set returned unexpected error: %v", err)
+	}
+	if err := list.Set("spiffe://example.org/db"); err != nil {
+		t.Fatalf("Set returned unexpected error: %v", err)
+	}
+
+	uris, err := list.URIs()
+	if err != nil {
+		t.Fatalf("URIs returned unexpected error: %v", err)
+	}
+	if len(uris) != 2 {
+		t.Fatalf("expected 2 URIs, got %d", len(uris))
+	}
+	if uris[0].String() != "spiffe://example.org/web" {
+		t.Errorf("uris[0] = %q, want %q", uris[0].String(), "spiffe://example.org/web")
+	}
+}
+
+func TestSpiffeIDListSetRejectsInvalid(t *testing.T) {
+	var list SpiffeIDList
+	if err := list.Set("not-a-spiffe-id"); err == nil {
+		t.Fatal("Set accepted an invalid SPIFFE ID")
+	}
+	if len(list) != 0 {
+		t.Errorf("invalid SPIFFE ID was appended to the list: %v", list)
+	}
+}